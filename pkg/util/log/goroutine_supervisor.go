@@ -0,0 +1,126 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RecoveredPanicsCount counts panics recovered and reported by Go /
+// GoWithRecover on supervised background goroutines. The panic is
+// re-raised after it is counted, so an increment is recorded just
+// before the panic continues to propagate.
+//
+// It is exported, and deliberately does not register itself into the
+// global Prometheus default registry on init() the way a standalone
+// package might: this codebase threads metrics through its own
+// per-component registry as part of server construction rather than
+// relying on the global Prometheus singleton, and an init()-time
+// MustRegister would also be a startup panic risk if some other
+// component ever registers a counter under the same fully-qualified
+// name. The component that owns the process' metrics registry is
+// expected to Register this counter there.
+var RecoveredPanicsCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "cockroach",
+	Subsystem: "panics",
+	Name:      "recovered_total",
+	Help:      "Number of panics recovered from supervised background goroutines (log.Go/log.GoWithRecover).",
+})
+
+// supervisedGoroutines tracks the goroutines currently running under
+// Go/GoWithRecover, keyed by an opaque id, so that ReportPanic can
+// include a summary of what else was running when a panic occurred --
+// often the only hint an operator gets, since a panic on a background
+// goroutine writes to the redirected stderr (i.e. the log file) and
+// never reaches the real terminal.
+var supervisedGoroutines sync.Map // map[int64]string (id -> name)
+
+var supervisedGoroutineSeq int64
+
+func registerSupervisedGoroutine(name string) int64 {
+	id := atomic.AddInt64(&supervisedGoroutineSeq, 1)
+	supervisedGoroutines.Store(id, name)
+	return id
+}
+
+func unregisterSupervisedGoroutine(id int64) {
+	supervisedGoroutines.Delete(id)
+}
+
+// supervisedGoroutineSummary returns a one-line summary of the
+// currently running supervised goroutines, for inclusion in panic
+// reports. It returns the empty string if none are running.
+func supervisedGoroutineSummary() string {
+	var names []string
+	supervisedGoroutines.Range(func(_, v interface{}) bool {
+		names = append(names, v.(string))
+		return true
+	})
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d sibling goroutines were running: %s", len(names), strings.Join(names, ", "))
+}
+
+// Go runs fn in a new goroutine, with automatic panic recovery and
+// reporting via GoWithRecover, under the supervision of this package
+// -- unlike a bare `go` statement, callers don't need to remember to
+// `defer RecoverAndReportPanic()` themselves.
+//
+// name identifies the goroutine in panic reports and in pprof
+// profiles (via pprof.SetGoroutineLabels), so it should be a short,
+// stable, human-readable label (e.g. "raft-scheduler" rather than a
+// per-call-site string).
+func Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	go GoWithRecover(ctx, name, fn)
+}
+
+// GoWithRecover runs fn with the same panic recovery, reporting, and
+// supervision as Go, but synchronously in the calling goroutine. Use
+// this when you already own the `go` statement (e.g. because you need
+// to pass additional arguments) and just want Go's recovery and
+// bookkeeping wrapped around the call.
+func GoWithRecover(ctx context.Context, name string, fn func(ctx context.Context)) {
+	id := registerSupervisedGoroutine(name)
+	defer unregisterSupervisedGoroutine(id)
+
+	ctx = pprof.WithLabels(ctx, pprof.Labels("log.Go", name))
+	pprof.SetGoroutineLabels(ctx)
+
+	// Deferred functions run LIFO: RecoverAndReportPanic (deferred
+	// last) runs first, recovering and reporting the panic via the
+	// shared ReportPanic/reportingPanic path before re-panicking; the
+	// wrapper below (deferred first) then recovers that re-panic just
+	// to bump the metric before letting it continue to propagate. This
+	// way the counter increment doesn't require duplicating
+	// RecoverAndReportPanic's own recover/report/re-panic sequence.
+	defer func() {
+		if r := recover(); r != nil {
+			RecoveredPanicsCount.Inc()
+			panic(r)
+		}
+	}()
+	defer RecoverAndReportPanic()
+
+	fn(ctx)
+}
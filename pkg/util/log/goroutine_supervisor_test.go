@@ -0,0 +1,86 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGoWithRecoverSupervisedSummary(t *testing.T) {
+	var wg sync.WaitGroup
+	var summary string
+	blockCh := make(chan struct{})
+
+	wg.Add(1)
+	// wg.Done runs after GoWithRecover returns, i.e. after its deferred
+	// unregisterSupervisedGoroutine, so the final check below is not racy.
+	go func() {
+		defer wg.Done()
+		GoWithRecover(context.Background(), "test-goroutine", func(ctx context.Context) {
+			<-blockCh
+		})
+	}()
+
+	// Give the goroutine a chance to register itself.
+	for i := 0; i < 1000 && summary == ""; i++ {
+		summary = supervisedGoroutineSummary()
+		if summary == "" {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(blockCh)
+	wg.Wait()
+
+	if !strings.Contains(summary, "test-goroutine") {
+		t.Fatalf("expected summary to mention test-goroutine, got %q", summary)
+	}
+	if got := supervisedGoroutineSummary(); got != "" {
+		t.Fatalf("expected no supervised goroutines after completion, got %q", got)
+	}
+}
+
+// TestGoWithRecoverPropagatesPanicAndCountsIt confirms that routing
+// panic recovery through RecoverAndReportPanic (rather than
+// duplicating its body) still lets the panic propagate to the caller,
+// and that RecoveredPanicsCount is bumped exactly once per panic.
+func TestGoWithRecoverPropagatesPanicAndCountsIt(t *testing.T) {
+	before := testutil.ToFloat64(RecoveredPanicsCount)
+
+	panicked := false
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = true
+			}
+		}()
+		GoWithRecover(context.Background(), "panicking-goroutine", func(ctx context.Context) {
+			panic("boom")
+		})
+	}()
+
+	if !panicked {
+		t.Fatal("expected GoWithRecover to propagate the panic to its caller")
+	}
+	if after := testutil.ToFloat64(RecoveredPanicsCount); after != before+1 {
+		t.Fatalf("expected RecoveredPanicsCount to increment by 1, got before=%v after=%v", before, after)
+	}
+}
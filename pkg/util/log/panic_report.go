@@ -0,0 +1,157 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/build"
+)
+
+// maxStackBufSize is the size of the buffer used to capture the
+// goroutine dump included in a panic report. It is intentionally
+// generous: a truncated dump is still useful, but we'd rather not
+// truncate under normal circumstances.
+const maxStackBufSize = 4 << 20 // 4MB
+
+// LogFilePath, when non-empty, names the log file currently in use,
+// and is included in panic reports so that a report made after the
+// terminal is gone still points post-mortem tooling at the right
+// file. Nothing in this package assigns it: it stays empty, and
+// reports omit the log file line, unless some caller sets it.
+var LogFilePath string
+
+// panicHook, if set via SetPanicHook, is invoked from ReportPanic with
+// the recovered value and the captured goroutine dump, in addition to
+// (not instead of) ReportPanic's own output. This lets higher layers
+// (e.g. a crash reporter / Sentry integration) observe panics without
+// racing with the runtime's own panic printer, since ReportPanic runs
+// under the reportingPanic guard below.
+//
+// It is stored in an atomic.Value, rather than a bare variable,
+// because SetPanicHook can legitimately race with a panic on another
+// goroutine that is already in flight (e.g. a crash reporter wiring
+// itself up during startup while other goroutines are already
+// running).
+var panicHook atomic.Value // func(r interface{}, stacks []byte)
+
+// SetPanicHook registers fn to be called by ReportPanic in addition to
+// its normal output. Only one hook can be registered at a time; a
+// later call replaces an earlier one.
+func SetPanicHook(fn func(r interface{}, stacks []byte)) {
+	panicHook.Store(fn)
+}
+
+// reportingPanic guards against ReportPanic recursing into itself --
+// e.g. if producing a report triggers another panic because
+// OrigStderr itself is broken -- without preventing later, unrelated
+// panics from being reported. It is not a "report at most once, ever"
+// switch: it is held only for the duration of a single synchronous
+// call to ReportPanic, then released, so a second, independent panic
+// reported later through this same exported entry point is still
+// fully reported. The flag is process-wide, not per goroutine: while
+// one report is in progress, a ReportPanic call on any other goroutine
+// returns without reporting.
+var reportingPanic int32
+
+// RecoverAndReportPanic can be invoked on goroutines that run with
+// stderr redirected to logs to ensure the user gets informed on the
+// real stderr a panic has occurred.
+func RecoverAndReportPanic() {
+	if r := recover(); r != nil {
+		ReportPanic(r)
+		panic(r)
+	}
+}
+
+// ReportPanic reports a panic has occurred on the real stderr, and
+// passes an enriched version of the same report -- including a full
+// goroutine stack dump, the build's tag/revision, the log file path
+// when LogFilePath is set, and the process' resource usage -- to
+// writeToLogFile. writeToLogFile is a no-op unless a caller assigns
+// it, so by default the report does not reach the log file.
+func ReportPanic(r interface{}) {
+	// Ensure that the logs are flushed before letting a panic
+	// terminate the server.
+	Flush()
+
+	if !atomic.CompareAndSwapInt32(&reportingPanic, 0, 1) {
+		// A report is already in progress, either on this call stack
+		// (this panic happened while building or emitting that earlier
+		// report) or on another goroutine; don't report this one.
+		return
+	}
+	defer atomic.StoreInt32(&reportingPanic, 0)
+
+	stacks := make([]byte, maxStackBufSize)
+	stacks = stacks[:runtime.Stack(stacks, true)]
+
+	report := buildPanicReport(r, stacks)
+
+	if stderrRedirected {
+		// The panic message will go to "stderr" which is actually the log
+		// file. Copy it to the real stderr to give the user a chance to
+		// see it.
+		fmt.Fprint(OrigStderr, report)
+	} else {
+		// We're not redirecting stderr at this point, so the panic
+		// message should be printed below. However we're not very strict
+		// in this package about whether "stderrRedirected" is accurate,
+		// so hint the user that they may still need to look at the log
+		// file.
+		fmt.Fprintln(OrigStderr, "\nERROR: a panic has occurred!\n"+
+			"If no details are printed below, check the log file for details.")
+	}
+
+	// Mirror the same report to the log file in a single write, so
+	// it cannot be interleaved with concurrent log output.
+	writeToLogFile([]byte(report))
+
+	if fn, ok := panicHook.Load().(func(r interface{}, stacks []byte)); ok {
+		fn(r, stacks)
+	}
+}
+
+// buildPanicReport assembles the enriched panic report text described
+// in ReportPanic's doc comment.
+func buildPanicReport(r interface{}, stacks []byte) string {
+	var buf bytes.Buffer
+	info := build.GetInfo()
+	fmt.Fprintf(&buf, "%v\n\n", r)
+	fmt.Fprintf(&buf, "*** build:    %s\n", info.Short())
+	if LogFilePath != "" {
+		fmt.Fprintf(&buf, "*** log file: %s\n", LogFilePath)
+	}
+	if ru := processRusage(); ru != "" {
+		fmt.Fprintf(&buf, "*** rusage:   %s\n", ru)
+	}
+	if summary := supervisedGoroutineSummary(); summary != "" {
+		fmt.Fprintf(&buf, "*** %s\n", summary)
+	}
+	fmt.Fprintf(&buf, "\n*** goroutine dump:\n%s\n", stacks)
+	return buf.String()
+}
+
+// writeToLogFile receives the panic report as a single buffer, for
+// emission as one write to the current log file. It is a variable,
+// rather than a direct call into the rest of this package's
+// file-writing machinery, purely to keep this file self-contained.
+// Its default is a no-op and nothing in this package replaces it, so
+// the report reaches the log file only if some caller assigns it.
+var writeToLogFile = func(buf []byte) {}
@@ -0,0 +1,79 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Severity identifies the severity of a single log entry written
+// through a SecondaryLogger.
+type Severity int32
+
+// The severity levels a SecondaryLogger entry can be logged at,
+// ordered from least to most severe.
+const (
+	Severity_INFO Severity = iota
+	Severity_WARNING
+	Severity_ERROR
+	Severity_FATAL
+)
+
+// String returns sev's canonical short name, as it appears in log
+// output (e.g. "ERROR").
+func (sev Severity) String() string {
+	switch sev {
+	case Severity_INFO:
+		return "INFO"
+	case Severity_WARNING:
+		return "WARNING"
+	case Severity_ERROR:
+		return "ERROR"
+	case Severity_FATAL:
+		return "FATAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SecondaryLogger is a log sink independent of the main logger,
+// suitable for dedicated log streams such as RedirectStderrToLog's
+// captured stderr. This is a minimal implementation -- it provides
+// only the severity-tagged Logf entry point that callers in this
+// package need -- rather than a full secondary-log-file
+// implementation with its own rotation and size limits.
+type SecondaryLogger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewSecondaryLogger returns a SecondaryLogger that writes its entries
+// to out.
+func NewSecondaryLogger(out io.Writer) *SecondaryLogger {
+	return &SecondaryLogger{out: out}
+}
+
+// Logf writes a single formatted entry at the given severity. ctx is
+// accepted for parity with the main logger's context-carrying log
+// entry points; this minimal implementation does not use it.
+func (l *SecondaryLogger) Logf(ctx context.Context, sev Severity, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%s: %s\n", sev, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// LogConfig holds logging feature flags that are set programmatically
+// by the component that owns the relevant log sink, as opposed to the
+// command-line flags handled elsewhere in this package.
+type LogConfig struct {
+	// RedirectStderr, when set, requests that fd 2 be captured into
+	// the log stream via RedirectStderrToLog. See ApplyConfig.
+	RedirectStderr bool
+	// RedirectStderrSeverity is the severity captured stderr lines are
+	// logged at when RedirectStderr is set. The zero value
+	// (Severity_INFO) is treated as unset and defaults to
+	// Severity_ERROR, since redirected stderr output -- uncaught
+	// panics, cgo assertions -- is overwhelmingly error-level by
+	// nature.
+	RedirectStderrSeverity Severity
+}
+
+// ApplyConfig applies cfg, wiring up any requested features against
+// sb. It is typically called once, early during server startup, after
+// the secondary logger that should receive the captured output has
+// been created.
+func ApplyConfig(cfg LogConfig, sb *SecondaryLogger) error {
+	if !cfg.RedirectStderr {
+		return nil
+	}
+	sev := cfg.RedirectStderrSeverity
+	if sev == 0 {
+		sev = Severity_ERROR
+	}
+	return RedirectStderrToLog(sb, sev)
+}
+
+// RedirectStderrToLog arranges for every write made to the process'
+// real stderr (fd 2) -- by this process' own Go runtime (unrecovered
+// panics, runtime.throw, race detector reports), by cgo assertions, or
+// by third-party C libraries that write to fd 2 directly (RocksDB,
+// Pebble, jemalloc) -- to be captured and emitted through sb instead
+// of being lost to a detached terminal. This mirrors the
+// "redirect_stderr" pattern used by mysqld and other servers with a
+// substantial C dependency surface.
+//
+// The pipe plumbing installed here is not tied to any log file: the
+// captured lines go to whatever sb writes to. SecondaryLogger itself
+// writes to a single fixed writer and does no rotation of its own.
+//
+// Callers that also want the original, un-redirected stderr should
+// keep using OrigStderr / OrigStderrFd, as with hijackStderr.
+func RedirectStderrToLog(sb *SecondaryLogger, sev Severity) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	if err := hijackStderr(int(w.Fd())); err != nil {
+		r.Close()
+		w.Close()
+		return err
+	}
+	// On Unix, hijackStderr dup2'd the write end onto fd 2, so our copy
+	// of it is now redundant and safe to close. On Windows, os.Stderr
+	// now shares the same handle as w, so closing it here would
+	// invalidate os.Stderr out from under us; closeHijackWriteEnd is a
+	// no-op there and w stays open for the life of the redirection.
+	if err := closeHijackWriteEnd(w); err != nil {
+		return err
+	}
+
+	go captureStderrLines(r, sb, sev)
+	return nil
+}
+
+// captureStderrLines reads from r, the read end of the pipe installed
+// by RedirectStderrToLog, and emits each line through sb at severity
+// sev. It uses a bufio.Reader rather than a bufio.Scanner because a
+// single write from a cgo library without an embedded newline (e.g. a
+// RocksDB or jemalloc assertion dump) can easily exceed Scanner's
+// fixed bufio.MaxScanTokenSize, which would make Scan() fail
+// permanently and silently kill stderr capture for the rest of the
+// process. ReadString has no such limit: it grows its buffer as
+// needed and only stops at the delimiter or at EOF.
+func captureStderrLines(r *os.File, sb *SecondaryLogger, sev Severity) {
+	ctx := context.Background()
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			sb.Logf(ctx, sev, "%s", strings.TrimRight(line, "\n"))
+		}
+		if err != nil {
+			if err != io.EOF {
+				sb.Logf(ctx, sev, "stderr capture stopped unexpectedly: %v", err)
+			}
+			return
+		}
+	}
+}
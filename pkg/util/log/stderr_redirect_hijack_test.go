@@ -0,0 +1,68 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// TestHijackStderrSurvivesWriteEndClose exercises the exact sequence
+// RedirectStderrToLog uses: hijack stderr onto a pipe's write end,
+// then have the caller let go of its own reference to that write end
+// via closeHijackWriteEnd. On Windows, SetStdHandle does not
+// duplicate the handle the way Unix's Dup2 does, so os.Stderr and w
+// end up sharing the same underlying handle; closing it out from
+// under os.Stderr must not happen. Writes through os.Stderr should
+// keep working, and should still reach the read end, regardless of
+// platform.
+func TestHijackStderrSurvivesWriteEndClose(t *testing.T) {
+	savedStderr := os.Stderr
+	defer func() { os.Stderr = savedStderr }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := hijackStderr(int(w.Fd())); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := restoreStderr(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err := closeHijackWriteEnd(w); err != nil {
+		t.Fatal(err)
+	}
+
+	const msg = "hello from hijacked stderr\n"
+	if _, err := os.Stderr.WriteString(msg); err != nil {
+		t.Fatalf("write to os.Stderr failed after closeHijackWriteEnd: %v", err)
+	}
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("got %q, want %q", buf, msg)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func init() {
+	fd, err := dupFD(uintptr(syscall.Stderr))
+	if err != nil {
+		panic(err)
+	}
+	OrigStderrFd = int(fd)
+	OrigStderr = os.NewFile(fd, "/dev/stderr")
+	if OrigStderr == nil {
+		panic(err)
+	}
+}
+
+// dupFD duplicates fd and marks the duplicate close-on-exec, so that
+// it is not inadvertently inherited by child processes spawned after
+// stderr has been hijacked (e.g. via exec.Command for backup/restore
+// helpers or debug subcommands). Where the kernel supports it, this
+// uses F_DUPFD_CLOEXEC to perform the dup and the close-on-exec flag
+// setting as a single atomic operation; otherwise it falls back to a
+// plain dup followed by F_SETFD, serialized against ForkLock so that
+// no concurrent fork() can observe the fd before it is marked
+// close-on-exec.
+func dupFD(fd uintptr) (uintptr, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+
+	newfd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD_CLOEXEC, 0)
+	if errno == 0 {
+		return newfd, nil
+	}
+
+	newfd, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, newfd, syscall.F_SETFD, syscall.FD_CLOEXEC); errno != 0 {
+		syscall.Close(int(newfd))
+		return 0, errno
+	}
+	return newfd, nil
+}
+
+// hijackStderr replaces syscall.Stderr (and thus the target of
+// os.Stderr and pretty much anything that targets stderr using
+// standard ways) by the given file descriptor.
+// A client that wishes to use the original stderr must use
+// OrigStderrFd / OrigStderr defined above.
+func hijackStderr(fd int) error {
+	stderrRedirected = true
+	return syscall.Dup2(fd, syscall.Stderr)
+}
+
+// restoreStderr cancels the effect of hijackStderr()
+func restoreStderr() error {
+	stderrRedirected = false
+	return syscall.Dup2(OrigStderrFd, syscall.Stderr)
+}
+
+// closeHijackWriteEnd closes w once it has served as the argument to a
+// successful hijackStderr() call. On Unix, Dup2 duplicates fd onto
+// slot 2, so w's own descriptor is independent of (and now redundant
+// with) fd 2, and closing it here is safe and avoids leaking it.
+func closeHijackWriteEnd(w *os.File) error {
+	return w.Close()
+}
+
+// processRusage returns a human-readable summary of the process'
+// resource usage, for inclusion in panic reports. An empty string is
+// returned if the usage could not be determined.
+func processRusage() string {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("utime=%d.%06ds stime=%d.%06ds maxrss=%dkB",
+		ru.Utime.Sec, ru.Utime.Usec, ru.Stime.Sec, ru.Stime.Usec, ru.Maxrss)
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// testDupFDHelperEnv names the environment variable TestDupFDCloseOnExec
+// uses to tell its re-exec'd helper process which fd number to probe.
+const testDupFDHelperEnv = "CRDB_TEST_DUP_FD_HELPER"
+
+// TestDupFDCloseOnExec verifies that dupFD marks its returned
+// descriptor close-on-exec, so that it does not leak into child
+// processes spawned after stderr has been hijacked.
+func TestDupFDCloseOnExec(t *testing.T) {
+	if fdStr := os.Getenv(testDupFDHelperEnv); fdStr != "" {
+		runDupFDHelperProcess(fdStr)
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	dupFd, err := dupFD(w.Fd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(int(dupFd))
+
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, dupFd, syscall.F_GETFD, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if flags&syscall.FD_CLOEXEC == 0 {
+		t.Fatalf("dupFD-returned descriptor is not close-on-exec (flags=%x)", flags)
+	}
+
+	// Re-exec this test binary as a child process and have it try to
+	// use the duplicated fd number directly: if dupFD's close-on-exec
+	// flag held, the fd won't exist in the child and the write below
+	// fails with EBADF; if it leaked, the write succeeds.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDupFDCloseOnExec$")
+	cmd.Env = append(os.Environ(), testDupFDHelperEnv+"="+strconv.FormatUint(uint64(dupFd), 10))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("helper process failed: %v\noutput:\n%s", err, out)
+	}
+	got := string(out)
+	if strings.Contains(got, "fd-inherited") {
+		t.Fatalf("dup'd fd %d was inherited by the child process, output:\n%s", dupFd, got)
+	}
+	if !strings.Contains(got, "fd-not-inherited") {
+		t.Fatalf("helper process did not report on fd %d, output:\n%s", dupFd, got)
+	}
+}
+
+// runDupFDHelperProcess is the body of TestDupFDCloseOnExec's re-exec'd
+// helper process. It reports over stdout whether the fd named by
+// testDupFDHelperEnv is usable in this process.
+func runDupFDHelperProcess(fdStr string) {
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := syscall.Write(fd, []byte("leaked")); err != nil {
+		fmt.Print("fd-not-inherited")
+		return
+	}
+	fmt.Print("fd-inherited")
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+
+//go:build windows
+// +build windows
+
+package log
+
+import (
+	"os"
+	"syscall"
+)
+
+// stdErrHandle is a variable rather than a constant because Go rejects
+// converting the negative constant -12 to uintptr.
+var stdErrHandle int32 = -12 // STD_ERROR_HANDLE, per the Windows API.
+
+// invalidHandleValue is INVALID_HANDLE_VALUE, the sentinel GetStdHandle
+// returns on failure. GetStdHandle only returns NULL (0) in the rare
+// case where the process has no associated standard handle at all.
+const invalidHandleValue = ^uintptr(0)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procSetStdHandle = modkernel32.NewProc("SetStdHandle")
+	procGetStdHandle = modkernel32.NewProc("GetStdHandle")
+)
+
+func init() {
+	h, _, err := procGetStdHandle.Call(uintptr(stdErrHandle))
+	if h == 0 || h == invalidHandleValue {
+		panic(err)
+	}
+	OrigStderrFd = int(h)
+	OrigStderr = os.NewFile(h, "/dev/stderr")
+	if OrigStderr == nil {
+		panic(err)
+	}
+}
+
+// hijackStderr replaces the process' stderr handle (and thus the
+// target of os.Stderr and pretty much anything that targets stderr
+// using standard ways) by the given file descriptor.
+//
+// Unlike on Unix, SetStdHandle() does not affect references to
+// os.Stderr, so hijackStderr also reassigns os.Stderr to the
+// replacement file; references to the old os.Stderr captured before
+// the call still write to the original handle.
+// A client that wishes to use the original stderr must use
+// OrigStderrFd / OrigStderr defined above.
+func hijackStderr(fd int) error {
+	stderrRedirected = true
+	ok, _, err := procSetStdHandle.Call(uintptr(stdErrHandle), uintptr(fd))
+	if ok == 0 {
+		return err
+	}
+	os.Stderr = os.NewFile(uintptr(fd), "/dev/stderr")
+	return nil
+}
+
+// restoreStderr cancels the effect of hijackStderr()
+func restoreStderr() error {
+	stderrRedirected = false
+	ok, _, err := procSetStdHandle.Call(uintptr(stdErrHandle), uintptr(OrigStderrFd))
+	if ok == 0 {
+		return err
+	}
+	os.Stderr = OrigStderr
+	return nil
+}
+
+// closeHijackWriteEnd is a no-op on Windows. hijackStderr() does not
+// duplicate the handle the way Unix's Dup2 does -- os.Stderr ends up
+// wrapping the very same handle value as w -- so closing w here would
+// call CloseHandle on the handle os.Stderr now depends on. w is kept
+// open for the lifetime of the redirection instead: it is kept
+// reachable in hijackWriteEnd so that its finalizer does not close the
+// handle when the caller drops its reference.
+func closeHijackWriteEnd(w *os.File) error {
+	hijackWriteEnd = w
+	return nil
+}
+
+// hijackWriteEnd holds the write end passed to the latest
+// closeHijackWriteEnd call.
+var hijackWriteEnd *os.File
+
+// processRusage returns a human-readable summary of the process'
+// resource usage, for inclusion in panic reports. Windows has no
+// direct equivalent of getrusage(2) exposed via syscall, so this
+// always returns the empty string.
+func processRusage() string {
+	return ""
+}